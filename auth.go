@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gorilla/securecookie"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
+)
+
+var (
+	oidcIssuer        = flag.String("oidc-issuer", "", "OIDC issuer URL; leave empty to disable login and accept self-asserted ids")
+	oidcClientID      = flag.String("oidc-client-id", "", "OIDC client ID")
+	oidcClientSecret  = flag.String("oidc-client-secret", "", "OIDC client secret")
+	oidcScopes        = flag.String("oidc-scopes", "openid,profile", "Comma-separated OIDC scopes to request")
+	oidcUsernameClaim = flag.String("oidc-username-claim", "sub", "ID token claim used as the authoritative gopher id")
+	oidcRedirectURL   = flag.String("oidc-redirect-url", "http://localhost:5001/callback", "OIDC redirect URL registered with the issuer")
+	oidcAutoOnboard   = flag.Bool("oidc-auto-onboard", true, "Provision a gopher at the origin on first login")
+)
+
+const (
+	flowCookieName    = "dancefloor_flow"
+	sessionCookieName = "dancefloor_session"
+)
+
+// session is the authenticated identity carried in the signed session
+// cookie that /add, /move, /del trust as the authoritative id.
+type session struct {
+	Subject string `json:"sub"`
+	Admin   bool   `json:"admin"`
+}
+
+// auth wires up the OIDC Authorization Code + PKCE flow and the signed,
+// HttpOnly session cookie it issues on success. A nil *auth means OIDC is
+// not configured, so handlers fall back to trusting the self-asserted id.
+type auth struct {
+	verifier     *oidc.IDTokenVerifier
+	oauth2Config oauth2.Config
+	cookies      *securecookie.SecureCookie
+}
+
+// newAuth discovers the issuer and builds the OIDC client. It returns a nil
+// *auth, nil error when -oidc-issuer is unset so OIDC remains fully optional.
+func newAuth(ctx context.Context) (*auth, error) {
+	if *oidcIssuer == "" {
+		return nil, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, *oidcIssuer)
+	if err != nil {
+		return nil, err
+	}
+
+	hashKey := securecookie.GenerateRandomKey(64)
+	blockKey := securecookie.GenerateRandomKey(32)
+
+	return &auth{
+		verifier: provider.Verifier(&oidc.Config{ClientID: *oidcClientID}),
+		oauth2Config: oauth2.Config{
+			ClientID:     *oidcClientID,
+			ClientSecret: *oidcClientSecret,
+			RedirectURL:  *oidcRedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       strings.Split(*oidcScopes, ","),
+		},
+		cookies: securecookie.New(hashKey, blockKey),
+	}, nil
+}
+
+func randomState() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// loginHandler starts the Authorization Code + PKCE flow, stashing the
+// state and PKCE verifier in a short-lived signed cookie for /callback.
+func (a *auth) loginHandler(w http.ResponseWriter, r *http.Request) {
+	state := randomState()
+	verifier := oauth2.GenerateVerifier()
+
+	value, err := a.cookies.Encode(flowCookieName, map[string]string{"state": state, "verifier": verifier})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flowCookieName,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   300,
+	})
+
+	http.Redirect(w, r, a.oauth2Config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), http.StatusFound)
+}
+
+// callbackHandler completes the flow: it exchanges the code, verifies the ID
+// token against the issuer's JWKS, and issues the session cookie handlers
+// use as the authoritative id. With -oidc-auto-onboard it also provisions a
+// gopher for first-time subjects.
+func callbackHandler(a *auth, ctx context.Context, rdb redis.UniversalClient, redisHash string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flowCookie, err := r.Cookie(flowCookieName)
+		if err != nil {
+			http.Error(w, "missing login state", http.StatusBadRequest)
+			return
+		}
+		var flow map[string]string
+		if err := a.cookies.Decode(flowCookieName, flowCookie.Value, &flow); err != nil {
+			http.Error(w, "invalid login state", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("state") != flow["state"] {
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			return
+		}
+
+		token, err := a.oauth2Config.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(flow["verifier"]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		rawIDToken, ok := token.Extra("id_token").(string)
+		if !ok {
+			http.Error(w, "token response did not include an id_token", http.StatusUnauthorized)
+			return
+		}
+		idToken, err := a.verifier.Verify(r.Context(), rawIDToken)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var claims map[string]interface{}
+		if err := idToken.Claims(&claims); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		subject, _ := claims[*oidcUsernameClaim].(string)
+		if subject == "" {
+			http.Error(w, "id token is missing the configured username claim", http.StatusUnauthorized)
+			return
+		}
+		admin, _ := claims["admin"].(bool)
+
+		sessionValue, err := a.cookies.Encode(sessionCookieName, session{Subject: subject, Admin: admin})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    sessionValue,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+			MaxAge:   int((24 * time.Hour).Seconds()),
+		})
+		http.SetCookie(w, &http.Cookie{Name: flowCookieName, Value: "", Path: "/", MaxAge: -1})
+
+		if *oidcAutoOnboard {
+			rdb.Do(ctx, "GEOADD", redisHash, "NX", 0, 0, subject)
+		}
+
+		fmt.Fprintf(w, "logged in as %s", subject)
+	}
+}
+
+func (a *auth) sessionFromRequest(r *http.Request) (*session, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, false
+	}
+	var sess session
+	if err := a.cookies.Decode(sessionCookieName, cookie.Value, &sess); err != nil {
+		return nil, false
+	}
+	return &sess, true
+}
+
+// authorizeID reports whether the caller may act as id. A nil *auth means
+// OIDC isn't configured, so the self-asserted id is trusted as before;
+// otherwise the session subject must match id unless it carries an admin
+// claim.
+func (a *auth) authorizeID(w http.ResponseWriter, r *http.Request, id string) bool {
+	if a == nil {
+		return true
+	}
+	sess, ok := a.sessionFromRequest(r)
+	if !ok {
+		http.Error(w, "login required", http.StatusUnauthorized)
+		return false
+	}
+	if sess.Subject != id && !sess.Admin {
+		http.Error(w, "id does not match the authenticated session", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fetchPageSize bounds how many members a single ZSCAN cursor page resolves,
+// so the no-bbox compatibility path never blocks Redis on a huge world.
+const fetchPageSize = 250
+
+// scanAllPositions walks redisHash with ZSCAN, resolving each page's members
+// to coordinates with GEOPOS, instead of pulling the whole world in one call.
+func scanAllPositions(ctx context.Context, rdb redis.UniversalClient, key string) (map[string]Position, error) {
+	positions := make(map[string]Position)
+	var cursor uint64
+	for {
+		members, next, err := rdb.ZScan(ctx, key, cursor, "", fetchPageSize).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]string, 0, len(members)/2)
+		for i := 0; i < len(members); i += 2 {
+			ids = append(ids, members[i])
+		}
+		if len(ids) > 0 {
+			if err := resolvePositions(ctx, rdb, key, ids, positions); err != nil {
+				return nil, err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	return positions, nil
+}
+
+// resolvePositions fills positions with the GEOPOS coordinates for ids.
+func resolvePositions(ctx context.Context, rdb redis.UniversalClient, key string, ids []string, positions map[string]Position) error {
+	coords, err := rdb.GeoPos(ctx, key, ids...).Result()
+	if err != nil {
+		return err
+	}
+	for i, c := range coords {
+		if c == nil {
+			continue
+		}
+		positions[ids[i]] = Position{X: c.Longitude, Y: c.Latitude}
+	}
+	return nil
+}
+
+// searchBBox returns every gopher within the viewport described by two
+// opposite corners. World coordinates are plain Cartesian world units (the
+// same metric validateMove's math.Hypot speed check uses), not geographic
+// degrees, so GEOSEARCH's BYBOX - which measures true spherical distance in
+// the given unit - can't filter them correctly; this walks
+// scanAllPositions' results and bounds-checks in Go instead.
+func searchBBox(ctx context.Context, rdb redis.UniversalClient, key string, x1, y1, x2, y2 float64) (map[string]Position, error) {
+	minX, maxX := x1, x2
+	if minX > maxX {
+		minX, maxX = maxX, minX
+	}
+	minY, maxY := y1, y2
+	if minY > maxY {
+		minY, maxY = maxY, minY
+	}
+
+	all, err := scanAllPositions(ctx, rdb, key)
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make(map[string]Position)
+	for id, pos := range all {
+		if pos.X >= minX && pos.X <= maxX && pos.Y >= minY && pos.Y <= maxY {
+			positions[id] = pos
+		}
+	}
+	return positions, nil
+}
+
+// searchNearby returns the gophers within radius world units of id. Like
+// searchBBox, this filters scanAllPositions' results with math.Hypot rather
+// than GEOSEARCH's BYRADIUS, so "radius" here means the same thing it does
+// to validateMove's speed check instead of real spherical meters.
+func searchNearby(ctx context.Context, rdb redis.UniversalClient, key, id string, radius float64) (map[string]Position, error) {
+	all, err := scanAllPositions(ctx, rdb, key)
+	if err != nil {
+		return nil, err
+	}
+
+	origin, ok := all[id]
+	if !ok {
+		return nil, fmt.Errorf("gopher %q not found", id)
+	}
+
+	positions := make(map[string]Position)
+	for otherID, pos := range all {
+		if otherID == id {
+			continue
+		}
+		if math.Hypot(pos.X-origin.X, pos.Y-origin.Y) <= radius {
+			positions[otherID] = pos
+		}
+	}
+	return positions, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// eventsChannel is the Redis Pub/Sub channel gopher deltas are published to.
+const eventsChannel = "gophers:events"
+
+// gopherEvent is the JSON delta published to eventsChannel and fanned out to
+// every connected /stream client in the same shape.
+type gopherEvent struct {
+	ID string  `json:"id"`
+	X  float64 `json:"x"`
+	Y  float64 `json:"y"`
+	Op string  `json:"op"`
+}
+
+// client is a single /stream subscriber with its own buffered outbound queue,
+// so one slow browser can't stall the others.
+type client struct {
+	send chan gopherEvent
+}
+
+// clientSendBuffer bounds how far a client can fall behind before it's
+// treated as a slow consumer and disconnected.
+const clientSendBuffer = 64
+
+// pongWait is how long we'll wait for a pong (or any other client frame)
+// before treating a WebSocket /stream connection as dead. pingPeriod must
+// stay well under pongWait so our own pings keep idle-but-healthy
+// connections from timing out.
+const pongWait = 60 * time.Second
+const pingPeriod = (pongWait * 9) / 10
+
+// hub fans gopherEvents out to every connected /stream client.
+type hub struct {
+	mu      sync.Mutex
+	clients map[*client]bool
+}
+
+func newHub() *hub {
+	return &hub{clients: make(map[*client]bool)}
+}
+
+func (h *hub) register(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = true
+	activeStreamClients.Inc()
+}
+
+func (h *hub) unregister(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	delete(h.clients, c)
+	close(c.send)
+	activeStreamClients.Dec()
+}
+
+func (h *hub) broadcast(ev gopherEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		select {
+		case c.send <- ev:
+		default:
+			// slow consumer: drop it instead of blocking the fan-out
+			delete(h.clients, c)
+			close(c.send)
+			activeStreamClients.Dec()
+		}
+	}
+}
+
+// publishEvent publishes a gopher delta to Redis so every process's hub,
+// including this one via its own subscription, can fan it out to clients.
+func publishEvent(ctx context.Context, rdb redis.UniversalClient, ev gopherEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return rdb.Publish(ctx, eventsChannel, payload).Err()
+}
+
+// subscribeEvents subscribes to eventsChannel and feeds every message into h
+// until ctx is cancelled. It is meant to run in its own goroutine per process.
+func subscribeEvents(ctx context.Context, rdb redis.UniversalClient, h *hub) {
+	sub := rdb.Subscribe(ctx, eventsChannel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var ev gopherEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+				log.Println("stream decode error", err)
+				continue
+			}
+			h.broadcast(ev)
+		}
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// streamHandler upgrades to a WebSocket when asked, otherwise falls back to
+// Server-Sent Events, and streams gopherEvents from h to the client.
+func streamHandler(h *hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			serveWebSocketStream(h, w, r)
+			return
+		}
+		serveSSEStream(h, w, r)
+	}
+}
+
+func serveWebSocketStream(h *hub, w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("websocket upgrade error", err)
+		return
+	}
+	defer conn.Close()
+
+	c := &client{send: make(chan gopherEvent, clientSendBuffer)}
+	h.register(c)
+	defer h.unregister(c)
+
+	closed := make(chan struct{})
+	go readPump(conn, closed)
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev, ok := <-c.send:
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// readPump discards every inbound message but still has to run: it's what
+// processes ping/pong and close control frames and notices a dead peer,
+// per gorilla/websocket's documented contract. It closes done once the
+// connection goes away, so the write loop stops waiting on a peer that
+// will never send another event.
+func readPump(conn *websocket.Conn, done chan struct{}) {
+	defer close(done)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func serveSSEStream(h *hub, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	c := &client{send: make(chan gopherEvent, clientSendBuffer)}
+	h.register(c)
+	defer h.unregister(c)
+
+	for {
+		select {
+		case ev, ok := <-c.send:
+			if !ok {
+				return
+			}
+			payload, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", payload)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
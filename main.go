@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -9,15 +11,23 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/garyburd/redigo/redis"
 	"github.com/newrelic/go-agent"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 )
 
 var (
-	redisAddress   = flag.String("redis-address", ":6379", "Address to the Redis server")
-	maxConnections = flag.Int("max-connections", 10, "Max connections to Redis")
-	httpPort       = flag.String("port", ":5001", "Port number to listen on")
-	licenseKey     = flag.String("license-key", "", "New Relic license key")
+	redisMode       = flag.String("redis-mode", "standalone", "Redis topology: standalone|sentinel|cluster")
+	redisAddress    = flag.String("redis-address", ":6379", "Address to the Redis server (standalone), or comma-separated cluster addresses")
+	redisMasterName = flag.String("redis-master-name", "", "Sentinel master name (sentinel mode)")
+	redisSentinels  = flag.String("redis-sentinels", "", "Comma-separated Sentinel addresses (sentinel mode)")
+	redisUsername   = flag.String("redis-username", "", "Redis ACL username")
+	redisPassword   = flag.String("redis-password", "", "Redis password")
+	redisDB         = flag.Int("redis-db", 0, "Redis logical database (standalone/sentinel only)")
+	redisTLS        = flag.Bool("redis-tls", false, "Connect to Redis over TLS")
+	maxConnections  = flag.Int("max-connections", 10, "Max connections to Redis")
+	httpPort        = flag.String("port", ":5001", "Port number to listen on")
+	licenseKey      = flag.String("license-key", "", "New Relic license key")
 )
 
 type Position struct {
@@ -35,10 +45,65 @@ func handleRedisError(w http.ResponseWriter, err error) {
 	log.Println("redis error", err)
 }
 
-func performRedisOperation(w http.ResponseWriter, p *redis.Pool, op string, args ...interface{}) (reply interface{}, err error) {
-	c := p.Get()
-	defer c.Close()
+// writeJSONPositions renders a gopher -> Position map as the JSON body
+// expected by /fetch and /nearby callers.
+func writeJSONPositions(w http.ResponseWriter, positions map[string]Position) {
+	returns := make(map[string]interface{}, len(positions))
+	for id, position := range positions {
+		returns[id] = position
+	}
+	body, err := json.Marshal(returns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Println("json marshal error", err)
+		return
+	}
+	fmt.Fprintf(w, "%s", string(body))
+}
+
+// newRedisClient builds a redis.UniversalClient for the configured topology, so the
+// rest of the program can stay agnostic to standalone vs. HA deployments.
+func newRedisClient() redis.UniversalClient {
+	var tlsConfig *tls.Config
+	if *redisTLS {
+		tlsConfig = &tls.Config{}
+	}
 
+	switch *redisMode {
+	case "sentinel":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    *redisMasterName,
+			SentinelAddrs: strings.Split(*redisSentinels, ","),
+			Username:      *redisUsername,
+			Password:      *redisPassword,
+			DB:            *redisDB,
+			PoolSize:      *maxConnections,
+			TLSConfig:     tlsConfig,
+		})
+	case "cluster":
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     strings.Split(*redisAddress, ","),
+			Username:  *redisUsername,
+			Password:  *redisPassword,
+			PoolSize:  *maxConnections,
+			TLSConfig: tlsConfig,
+		})
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:      *redisAddress,
+			Username:  *redisUsername,
+			Password:  *redisPassword,
+			DB:        *redisDB,
+			PoolSize:  *maxConnections,
+			TLSConfig: tlsConfig,
+		})
+	}
+}
+
+// performRedisOperation runs fn inside a New Relic datastore segment and an
+// OTel span labelled with op, recording Prometheus latency, and translating
+// any error into an HTTP response.
+func performRedisOperation(ctx context.Context, w http.ResponseWriter, op string, fn func() error) error {
 	s := newrelic.DatastoreSegment{
 		Product:    newrelic.DatastoreRedis,
 		Collection: "gophers",
@@ -48,13 +113,13 @@ func performRedisOperation(w http.ResponseWriter, p *redis.Pool, op string, args
 	if txn, ok := w.(newrelic.Transaction); ok {
 		s.StartTime = newrelic.StartSegmentNow(txn)
 	}
-	reply, err = c.Do(op, args...)
+	err := instrumentRedisOp(ctx, op, fn)()
 	s.End()
 	if err != nil {
 		handleRedisError(w, err)
-		return nil, err
+		return err
 	}
-	return reply, nil
+	return nil
 }
 
 func main() {
@@ -68,31 +133,63 @@ func main() {
 		log.Println("error creating new relic agent", err)
 	}
 
-	redisHash := "gophers" // redis hash name where data is persisted
+	redisHash := "gophers" // redis GEO sorted-set key where positions are persisted
 
-	redisPool := redis.NewPool(func() (redis.Conn, error) {
-		c, err := redis.Dial("tcp", *redisAddress)
-		if err != nil {
-			log.Println("error connection to redis", err)
-			return nil, err
-		}
-		return c, err
-	}, *maxConnections)
-	defer redisPool.Close()
+	ctx := context.Background()
+	rdb := newRedisClient()
+	defer rdb.Close()
+
+	gopherHub := newHub()
+	go subscribeEvents(ctx, rdb, gopherHub)
+	go pollRedisPoolStats(ctx, rdb)
+
+	// *streamConsumerGroup is reserved for external analytics/replay/
+	// moderation workers: pre-create it so they have somewhere to attach,
+	// but never read from it ourselves (see internalConsumerGroup's doc
+	// comment for why).
+	if err := ensureConsumerGroup(ctx, rdb, *streamConsumerGroup); err != nil {
+		log.Println("error creating external stream consumer group", err)
+	}
+
+	streamReaperConsumer := uniqueConsumerName("reaper")
+	streamConsumerName := uniqueConsumerName("dancefloor")
+	if err := ensureConsumerGroup(ctx, rdb, internalConsumerGroup); err != nil {
+		log.Println("error creating internal stream consumer group", err)
+	} else {
+		go consumeEvents(ctx, rdb, internalConsumerGroup, streamConsumerName)
+		go reclaimStaleEntries(ctx, rdb, internalConsumerGroup, streamReaperConsumer, *streamClaimMinIdle)
+	}
+
+	oidcAuth, err := newAuth(ctx)
+	if err != nil {
+		log.Println("error configuring OIDC auth", err)
+	}
+
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		log.Println("error configuring OpenTelemetry tracing", err)
+	} else {
+		defer shutdownTracing(ctx)
+	}
 
 	log.Println("Listening on port:", *httpPort)
 
+	if oidcAuth != nil {
+		http.HandleFunc("/login", instrumentHandler("/login", oidcAuth.loginHandler))
+		http.HandleFunc("/callback", instrumentHandler("/callback", callbackHandler(oidcAuth, ctx, rdb, redisHash)))
+	}
+
 	// error
-	http.HandleFunc(newrelic.WrapHandleFunc(app, "/error", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc(newrelic.WrapHandleFunc(app, "/error", instrumentHandler("/error", func(w http.ResponseWriter, r *http.Request) {
 		msg := r.URL.Query().Get("msg")
 		if msg == "" {
 			msg = "This error has been automatically generated."
 		}
 		http.Error(w, msg, http.StatusInternalServerError)
-	}))
+	})))
 
 	// add
-	http.HandleFunc(newrelic.WrapHandleFunc(app, "/add", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc(newrelic.WrapHandleFunc(app, "/add", instrumentHandler("/add", func(w http.ResponseWriter, r *http.Request) {
 		id := r.URL.Query().Get("id")
 		x := r.URL.Query().Get("x")
 		y := r.URL.Query().Get("y")
@@ -101,30 +198,62 @@ func main() {
 			handleParamsError(w)
 			return
 		}
+		if !oidcAuth.authorizeID(w, r, id) {
+			return
+		}
 
-		_, err := performRedisOperation(w, redisPool, "HSETNX", redisHash, id, fmt.Sprintf("%s,%s", x, y))
+		xFloat, err := strconv.ParseFloat(x, 64)
+		if err != nil {
+			handleParamsError(w)
+			return
+		}
+		yFloat, err := strconv.ParseFloat(y, 64)
+		if err != nil {
+			handleParamsError(w)
+			return
+		}
+		xFloat, yFloat, ok := clampPosition(xFloat, yFloat)
+		if !ok {
+			handleParamsError(w)
+			return
+		}
+
+		err = performRedisOperation(ctx, w, "GEOADD", func() error {
+			return rdb.Do(ctx, "GEOADD", redisHash, "NX", xFloat, yFloat, id).Err()
+		})
 		if err == nil {
+			ev := gopherEvent{ID: id, X: xFloat, Y: yFloat, Op: "add"}
+			publishEvent(ctx, rdb, ev)
+			mirrorEvent(ctx, rdb, ev)
 			fmt.Fprintf(w, "ok")
 		}
-	}))
+	})))
 
 	// del
-	http.HandleFunc(newrelic.WrapHandleFunc(app, "/del", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc(newrelic.WrapHandleFunc(app, "/del", instrumentHandler("/del", func(w http.ResponseWriter, r *http.Request) {
 		id := r.URL.Query().Get("id")
 
 		if id == "" {
 			handleParamsError(w)
 			return
 		}
+		if !oidcAuth.authorizeID(w, r, id) {
+			return
+		}
 
-		_, err := performRedisOperation(w, redisPool, "HDEL", redisHash, id)
+		err := performRedisOperation(ctx, w, "ZREM", func() error {
+			return rdb.ZRem(ctx, redisHash, id).Err()
+		})
 		if err == nil {
+			ev := gopherEvent{ID: id, Op: "del"}
+			publishEvent(ctx, rdb, ev)
+			mirrorEvent(ctx, rdb, ev)
 			fmt.Fprintf(w, "ok")
 		}
-	}))
+	})))
 
 	// move
-	http.HandleFunc(newrelic.WrapHandleFunc(app, "/move", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc(newrelic.WrapHandleFunc(app, "/move", instrumentHandler("/move", func(w http.ResponseWriter, r *http.Request) {
 		id := r.URL.Query().Get("id")
 		x := r.URL.Query().Get("x")
 		y := r.URL.Query().Get("y")
@@ -133,41 +262,125 @@ func main() {
 			handleParamsError(w)
 			return
 		}
+		if !oidcAuth.authorizeID(w, r, id) {
+			return
+		}
+
+		xFloat, err := strconv.ParseFloat(x, 64)
+		if err != nil {
+			handleParamsError(w)
+			return
+		}
+		yFloat, err := strconv.ParseFloat(y, 64)
+		if err != nil {
+			handleParamsError(w)
+			return
+		}
+		xFloat, yFloat, ok := clampPosition(xFloat, yFloat)
+		if !ok {
+			handleParamsError(w)
+			return
+		}
+
+		remaining, ok, err := checkRateLimit(ctx, rdb, id)
+		if err != nil {
+			handleRedisError(w, err)
+			return
+		}
+		if !ok {
+			writeRateLimited(w)
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+
+		valid, err := validateMove(ctx, rdb, redisHash, id, xFloat, yFloat)
+		if err != nil {
+			handleRedisError(w, err)
+			return
+		}
+		if !valid {
+			http.Error(w, "move exceeds max speed", http.StatusBadRequest)
+			return
+		}
 
-		_, err := performRedisOperation(w, redisPool, "HSET", redisHash, id, fmt.Sprintf("%s, %s", x, y))
+		err = performRedisOperation(ctx, w, "GEOADD", func() error {
+			return rdb.GeoAdd(ctx, redisHash, &redis.GeoLocation{Name: id, Longitude: xFloat, Latitude: yFloat}).Err()
+		})
 		if err == nil {
+			recordMove(ctx, rdb, id)
+			ev := gopherEvent{ID: id, X: xFloat, Y: yFloat, Op: "move"}
+			publishEvent(ctx, rdb, ev)
+			mirrorEvent(ctx, rdb, ev)
 			fmt.Fprintf(w, "ok")
 		}
-	}))
+	})))
 
-	// fetch
-	http.HandleFunc(newrelic.WrapHandleFunc(app, "/fetch", func(w http.ResponseWriter, r *http.Request) {
-		values, err := redis.Values(performRedisOperation(w, redisPool, "HGETALL", redisHash))
-		if err != nil {
+	// stream
+	http.HandleFunc("/stream", instrumentHandler("/stream", streamHandler(gopherHub)))
+
+	// replay
+	http.HandleFunc("/replay", instrumentHandler("/replay", replayHandler(rdb)))
+
+	// metrics
+	http.Handle("/metrics", promhttp.Handler())
+
+	// nearby
+	http.HandleFunc(newrelic.WrapHandleFunc(app, "/nearby", instrumentHandler("/nearby", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		radiusParam := r.URL.Query().Get("radius")
+		if id == "" || radiusParam == "" {
+			handleParamsError(w)
 			return
 		}
-		returns := make(map[string]interface{})
-		for i := 0; i < len(values); i += 2 {
-			id, _ := redis.String(values[i], nil)
-			value, _ := redis.String(values[i+1], nil)
-			positions := strings.Split(value, ",")
-			x, _ := strconv.ParseFloat(positions[0], 64)
-			y, _ := strconv.ParseFloat(positions[1], 64)
-			position := Position{X: x, Y: y}
-			returns[id] = position
+		radius, err := strconv.ParseFloat(radiusParam, 64)
+		if err != nil {
+			handleParamsError(w)
+			return
 		}
-		json, err := json.Marshal(returns)
+
+		var returns map[string]Position
+		err = performRedisOperation(ctx, w, "ZSCAN", func() (err error) {
+			returns, err = searchNearby(ctx, rdb, redisHash, id, radius)
+			return err
+		})
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			if txn, ok := w.(newrelic.Transaction); ok {
-				txn.NoticeError(err)
+			return
+		}
+		writeJSONPositions(w, returns)
+	})))
+
+	// fetch
+	http.HandleFunc(newrelic.WrapHandleFunc(app, "/fetch", instrumentHandler("/fetch", func(w http.ResponseWriter, r *http.Request) {
+		bbox := r.URL.Query().Get("bbox")
+
+		var returns map[string]Position
+		var err error
+		if bbox != "" {
+			corners := strings.Split(bbox, ",")
+			if len(corners) != 4 {
+				handleParamsError(w)
+				return
 			}
+			x1, _ := strconv.ParseFloat(corners[0], 64)
+			y1, _ := strconv.ParseFloat(corners[1], 64)
+			x2, _ := strconv.ParseFloat(corners[2], 64)
+			y2, _ := strconv.ParseFloat(corners[3], 64)
 
-			log.Println("json marshal error", err)
+			err = performRedisOperation(ctx, w, "ZSCAN", func() (err error) {
+				returns, err = searchBBox(ctx, rdb, redisHash, x1, y1, x2, y2)
+				return err
+			})
+		} else {
+			err = performRedisOperation(ctx, w, "ZSCAN", func() (err error) {
+				returns, err = scanAllPositions(ctx, rdb, redisHash)
+				return err
+			})
+		}
+		if err != nil {
 			return
 		}
-		fmt.Fprintf(w, "%s", string(json))
-	}))
+		writeJSONPositions(w, returns)
+	})))
 
 	http.ListenAndServe(*httpPort, nil)
 }
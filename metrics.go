@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// otlpEndpoint configures the vendor-neutral tracing path alongside the
+// existing New Relic segments; tracing is a no-op when it's unset.
+var otlpEndpoint = flag.String("otlp-endpoint", "", "OTLP/gRPC exporter endpoint for OpenTelemetry tracing; leave empty to disable")
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dancefloor_http_requests_total",
+		Help: "Total HTTP requests, labelled by route and status.",
+	}, []string{"route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "dancefloor_http_request_duration_seconds",
+		Help: "HTTP request latency in seconds, labelled by route.",
+	}, []string{"route"})
+
+	redisCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "dancefloor_redis_command_duration_seconds",
+		Help: "Redis command latency in seconds, labelled by operation.",
+	}, []string{"op"})
+
+	activeStreamClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dancefloor_active_stream_clients",
+		Help: "Number of currently connected /stream clients.",
+	})
+
+	// redisPoolWaitTimeouts tracks go-redis's PoolStats().Timeouts: the
+	// cumulative number of times a caller waiting for a pooled connection
+	// hit the pool's wait timeout. go-redis v9.5.1 doesn't expose an actual
+	// wait-duration histogram (that field was added in a later version), so
+	// this is the closest available signal for Redis pool contention.
+	redisPoolWaitTimeouts = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dancefloor_redis_pool_wait_timeouts",
+		Help: "Cumulative number of times a caller waiting for a Redis pool connection hit the pool's wait timeout.",
+	})
+)
+
+var tracer = otel.Tracer("dancefloor")
+
+// setupTracing configures a global OTel TracerProvider exporting to
+// -otlp-endpoint and returns a shutdown func. When -otlp-endpoint is unset,
+// it returns a no-op shutdown and leaves the global no-op tracer in place.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if *otlpEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(*otlpEndpoint), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewSchemaless(attribute.String("service.name", "dancefloor"))
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// redisPoolStatsInterval bounds how often pollRedisPoolStats samples
+// PoolStats, since it's a point-in-time counter snapshot rather than
+// something Redis pushes on change.
+const redisPoolStatsInterval = 15 * time.Second
+
+// pollRedisPoolStats periodically samples rdb's connection pool stats into
+// redisPoolWaitTimeouts until ctx is cancelled. It is meant to run in its
+// own goroutine per process.
+func pollRedisPoolStats(ctx context.Context, rdb redis.UniversalClient) {
+	ticker := time.NewTicker(redisPoolStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		stats := rdb.PoolStats()
+		redisPoolWaitTimeouts.Set(float64(stats.Timeouts))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// statusRecorder captures the status code a handler writes, since
+// http.ResponseWriter doesn't expose it after the fact.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack lets instrumented handlers still upgrade connections (e.g. /stream's
+// WebSocket path): net/http only promotes Hijacker through the wrapped
+// ResponseWriter's concrete type, not through the embedded interface.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("statusRecorder: underlying ResponseWriter does not support hijacking")
+	}
+	return hj.Hijack()
+}
+
+// Flush forwards to the underlying ResponseWriter for the same reason
+// Hijack does, so instrumented streaming handlers (/stream's SSE fallback,
+// /replay) still see an http.Flusher.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrumentHandler wraps next with Prometheus HTTP metrics and an OTel span
+// named after route, independent of whether New Relic is also wrapping it.
+func instrumentHandler(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), route)
+		defer span.End()
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r.WithContext(ctx))
+
+		httpRequestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+		httpRequestsTotal.WithLabelValues(route, strconv.Itoa(rec.status)).Inc()
+	}
+}
+
+// instrumentRedisOp wraps fn with a Redis command latency histogram and an
+// OTel span labelled by op, so performRedisOperation's callers get both
+// without repeating the bookkeeping at every call site.
+func instrumentRedisOp(ctx context.Context, op string, fn func() error) func() error {
+	return func() error {
+		_, span := tracer.Start(ctx, "redis."+op, trace.WithAttributes(attribute.String("redis.op", op)))
+		defer span.End()
+
+		start := time.Now()
+		err := fn()
+		redisCommandDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+		if err != nil {
+			span.RecordError(err)
+		}
+		return err
+	}
+}
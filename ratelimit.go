@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// World coordinates are stored directly as Redis GEO longitude/latitude, so
+// the bounding box defaults are constrained to valid GEO ranges
+// (longitude in [-180, 180], latitude in [-85.05112878, 85.05112878]) to
+// keep GEOADD/GEOSEARCH from rejecting every position with "invalid
+// longitude,latitude pair". Operators narrowing the box for a smaller
+// dance floor must stay inside those bounds.
+var (
+	worldMinX = flag.Float64("world-min-x", -180, "Minimum X (GEO longitude) coordinate of the world bounding box")
+	worldMaxX = flag.Float64("world-max-x", 180, "Maximum X (GEO longitude) coordinate of the world bounding box")
+	worldMinY = flag.Float64("world-min-y", -85, "Minimum Y (GEO latitude) coordinate of the world bounding box")
+	worldMaxY = flag.Float64("world-max-y", 85, "Maximum Y (GEO latitude) coordinate of the world bounding box")
+	maxSpeed  = flag.Float64("max-speed", 50, "Maximum allowed movement speed, in world units per second")
+
+	rateLimitMax    = flag.Int("rate-limit-max", 20, "Max requests per id within rate-limit-window")
+	rateLimitWindow = flag.Duration("rate-limit-window", time.Second, "Rate-limit window duration")
+)
+
+// rateLimitScript is the standard Redis INCR+PEXPIRE rate-limit recipe,
+// run atomically so concurrent requests for the same id can't race past it.
+var rateLimitScript = redis.NewScript(`
+local current = redis.call('INCR', KEYS[1])
+if current == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+if current > tonumber(ARGV[2]) then
+	return -1
+end
+return tonumber(ARGV[2]) - current
+`)
+
+// checkRateLimit reports id's remaining request budget for the current
+// window, or ok=false once the configured limit has been exceeded.
+func checkRateLimit(ctx context.Context, rdb redis.UniversalClient, id string) (remaining int64, ok bool, err error) {
+	key := fmt.Sprintf("rl:{%s}", id)
+	result, err := rateLimitScript.Run(ctx, rdb, []string{key}, rateLimitWindow.Milliseconds(), *rateLimitMax).Int64()
+	if err != nil {
+		return 0, false, err
+	}
+	if result < 0 {
+		return 0, false, nil
+	}
+	return result, true, nil
+}
+
+// writeRateLimited responds 429 with a Retry-After sized to the configured
+// rate-limit window.
+func writeRateLimited(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(rateLimitWindow.Seconds())))
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
+
+// clampPosition rejects non-finite coordinates and clamps everything else to
+// the configured world bounding box.
+func clampPosition(x, y float64) (clampedX, clampedY float64, ok bool) {
+	if math.IsNaN(x) || math.IsInf(x, 0) || math.IsNaN(y) || math.IsInf(y, 0) {
+		return 0, 0, false
+	}
+	clampedX = math.Min(math.Max(x, *worldMinX), *worldMaxX)
+	clampedY = math.Min(math.Max(y, *worldMinY), *worldMaxY)
+	return clampedX, clampedY, true
+}
+
+// lastMoveKey is where recordMove stamps id's last-accepted-move time, for
+// validateMove to measure dt against.
+func lastMoveKey(id string) string {
+	return fmt.Sprintf("lastmove:{%s}", id)
+}
+
+// validateMove reports whether a move to x,y for id stays within
+// maxSpeed*dt of its last known position, reading that position back via
+// GEOPOS and id's last recorded move time. It does not record this
+// attempt: call recordMove once the move has actually been written, so a
+// rejected attempt never becomes the basis for the next dt calculation.
+func validateMove(ctx context.Context, rdb redis.UniversalClient, key, id string, x, y float64) (bool, error) {
+	coords, err := rdb.GeoPos(ctx, key, id).Result()
+	if err != nil {
+		return false, err
+	}
+
+	lastNano, err := rdb.Get(ctx, lastMoveKey(id)).Int64()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+
+	if len(coords) == 0 || coords[0] == nil || lastNano == 0 {
+		// no prior position on record: nothing to validate a jump against
+		return true, nil
+	}
+
+	dt := time.Since(time.Unix(0, lastNano)).Seconds()
+	if dt <= 0 {
+		dt = 0.001
+	}
+
+	distance := math.Hypot(x-coords[0].Longitude, y-coords[0].Latitude)
+	return distance <= *maxSpeed*dt, nil
+}
+
+// recordMove stamps id's last-accepted-move time. Callers must only invoke
+// this once the move has actually landed in Redis.
+func recordMove(ctx context.Context, rdb redis.UniversalClient, id string) {
+	rdb.Set(ctx, lastMoveKey(id), time.Now().UnixNano(), time.Minute)
+}
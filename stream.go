@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// logStream is the Redis Stream every /add, /move, /del mirrors into, giving
+// the dance floor a durable, replayable audit log.
+const logStream = "gophers:log"
+
+var (
+	streamMaxLen        = flag.Int64("stream-maxlen", 10000, "Approximate MAXLEN cap for the gophers:log stream")
+	streamConsumerGroup = flag.String("stream-consumer-group", "dancefloor-reaper", "Consumer group reserved for external analytics/replay/moderation consumers of gophers:log; this process only pre-creates it and never reads from it")
+	streamClaimMinIdle  = flag.Duration("stream-claim-min-idle", 30*time.Second, "Minimum idle time before a pending entry is reclaimed via XAUTOCLAIM")
+)
+
+// internalConsumerGroup is the group consumeEvents/reclaimStaleEntries use
+// for this process's own housekeeping reads of logStream. It is deliberately
+// separate from *streamConsumerGroup: consumer groups load-balance entries
+// across their members rather than broadcasting, so sharing a group with
+// external consumers would mean this process randomly steals and discards a
+// fraction of every external worker's events instead of letting them see it.
+const internalConsumerGroup = "dancefloor-internal"
+
+// uniqueConsumerName builds a Redis Streams consumer identity from the
+// process's hostname and PID, so running more than one instance of this
+// service against the same consumer group doesn't collide two processes
+// onto the same consumer name and corrupt at-least-once delivery.
+func uniqueConsumerName(prefix string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%s-%d", prefix, host, os.Getpid())
+}
+
+// mirrorEvent appends ev to logStream with an approximate MAXLEN trim, so
+// /replay and downstream consumers see the same deltas /stream fans out live.
+func mirrorEvent(ctx context.Context, rdb redis.UniversalClient, ev gopherEvent) error {
+	return rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: logStream,
+		MaxLen: *streamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"op": ev.Op,
+			"id": ev.ID,
+			"x":  ev.X,
+			"y":  ev.Y,
+		},
+	}).Err()
+}
+
+// ensureConsumerGroup creates group on logStream if it doesn't already exist,
+// tolerating the BUSYGROUP error Redis returns when it does.
+func ensureConsumerGroup(ctx context.Context, rdb redis.UniversalClient, group string) error {
+	err := rdb.XGroupCreateMkStream(ctx, logStream, group, "$").Err()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// streamReadBlock bounds how long a single XREADGROUP call waits for new
+// entries before looping, so consumeEvents can still notice ctx cancellation.
+const streamReadBlock = 5 * time.Second
+
+// streamReadErrorBackoff throttles consumeEvents' retry loop on a
+// persistent (non-Nil) XREADGROUP error, so a Redis outage doesn't turn
+// into a tight reconnect loop.
+const streamReadErrorBackoff = time.Second
+
+// consumeEvents is this process's own at-least-once consumer side of the
+// audit log: it reads new logStream entries into group's PEL via
+// XREADGROUP under consumer, and XACKs each one once handled so
+// reclaimStaleEntries has a non-empty PEL to recover from if consumer dies
+// mid-batch. group must be internalConsumerGroup, not *streamConsumerGroup:
+// see internalConsumerGroup's doc comment for why.
+func consumeEvents(ctx context.Context, rdb redis.UniversalClient, group, consumer string) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		streams, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: consumer,
+			Streams:  []string{logStream, ">"},
+			Count:    100,
+			Block:    streamReadBlock,
+		}).Result()
+		if err != nil {
+			if err != redis.Nil && ctx.Err() == nil {
+				log.Println("stream read error", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(streamReadErrorBackoff):
+				}
+			}
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				// No downstream processing yet; XACK here just retires the
+				// entry from this process's own internal PEL.
+				rdb.XAck(ctx, logStream, group, msg.ID)
+			}
+		}
+	}
+}
+
+// reclaimStaleEntries runs XAUTOCLAIM on a timer so entries left pending by a
+// dead consumer are handed to consumer instead of waiting forever for an ACK
+// that will never come.
+func reclaimStaleEntries(ctx context.Context, rdb redis.UniversalClient, group, consumer string, minIdle time.Duration) {
+	ticker := time.NewTicker(minIdle)
+	defer ticker.Stop()
+
+	cursor := "0-0"
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		messages, next, err := rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   logStream,
+			Group:    group,
+			Consumer: consumer,
+			MinIdle:  minIdle,
+			Start:    cursor,
+			Count:    100,
+		}).Result()
+		if err != nil {
+			log.Println("stream claim error", err)
+			continue
+		}
+		cursor = next
+		for _, msg := range messages {
+			rdb.XAck(ctx, logStream, group, msg.ID)
+		}
+	}
+}
+
+// replayHandler streams every logStream entry from ID "from" onward as
+// newline-delimited JSON, so a newly joined browser can rebuild state
+// without waiting for the next live move.
+func replayHandler(rdb redis.UniversalClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from := r.URL.Query().Get("from")
+		if from == "" {
+			from = "-"
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		entries, err := rdb.XRange(r.Context(), logStream, from, "+").Result()
+		if err != nil {
+			handleRedisError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, entry := range entries {
+			payload, err := json.Marshal(entry.Values)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "%s\n", payload)
+			flusher.Flush()
+		}
+	}
+}